@@ -13,10 +13,11 @@
 package hashcounter
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/bits"
+	"slices"
 
 	"github.com/cespare/xxhash"
 )
@@ -32,6 +33,14 @@ const (
 type C struct {
 	arr  [1 << part1Size][]uint64
 	hash func([]byte) uint64
+	// hashString, if set, is used by KeyString instead of hash
+	hashString func(string) uint64
+
+	// if nodes is non-zero then only partitions owned by self are allowed
+	nodes, self int
+
+	threshold   uint16
+	onThreshold func(key uint64, count uint16)
 }
 
 // New returns a new instance of C
@@ -46,6 +55,29 @@ func NewWithHash(fn func([]byte) uint64) *C {
 	}
 }
 
+// WithThreshold sets a function that's called when adding to a key causes its
+// value to go from below limit to at or above it. Since values only go up when
+// adding, fn is called at most once per key unless its value is lowered with
+// Set or wraps around. Only Add, AddKey, AddString, AddAll, AddSortedKeys,
+// Incr, IncrKey, IncrementAndGet and GetOrAdd call fn. Since no value can be
+// below 0, a limit of 0 never calls fn and effectively disables it. It returns
+// m so it can be chained with New.
+func (m *C) WithThreshold(limit uint16, fn func(key uint64, count uint16)) *C {
+	m.threshold = limit
+	m.onThreshold = fn
+	return m
+}
+
+// NewWithHashes returns a new instance of C with the provided hash functions.
+// fnString must return the same key for a string as fn does for its bytes.
+// It's used by KeyString so that hashing strings doesn't need to allocate.
+func NewWithHashes(fn func([]byte) uint64, fnString func(string) uint64) *C {
+	return &C{
+		hash:       fn,
+		hashString: fnString,
+	}
+}
+
 // Key returns the uint64 key for the given bytes
 func (m *C) Key(k []byte) uint64 {
 	if m.hash != nil {
@@ -54,27 +86,243 @@ func (m *C) Key(k []byte) uint64 {
 	return xxhash.Sum64(k)
 }
 
-func (m *C) loc(k uint64) (uint16, uint64) {
-	return uint16(k >> (64 - part1Size)), k & idBits
+// KeyString returns the uint64 key for the given string. This does not
+// allocate when using the default hash function or a string hash function
+// passed to NewWithHashes. Otherwise a custom hash function is passed a copy
+// of the string as a byte slice.
+func (m *C) KeyString(s string) uint64 {
+	if m.hashString != nil {
+		return m.hashString(s)
+	}
+	if m.hash != nil {
+		return m.hash([]byte(s))
+	}
+	return xxhash.Sum64String(s)
+}
+
+// loc is just within the inlining budget, so check -gcflags=-m still reports
+// it as inlinable after changing it
+func (m *C) loc(k uint64) (p1 uint16, id uint64) {
+	p1, id = PartitionOf(k), k&idBits
+	if m.nodes > 0 {
+		m.checkOwned(p1)
+	}
+	return
+}
+
+// checkOwned panics if p1 isn't owned by this node. It's kept out of loc so
+// loc can still be inlined.
+func (m *C) checkOwned(p1 uint16) {
+	if !OwnedBy(p1, m.nodes, m.self) {
+		panic(fmt.Sprintf("hashcounter: partition %d is not owned by node %d of %d", p1, m.self, m.nodes))
+	}
+}
+
+// checkThreshold calls onThreshold if the value of the id in the given
+// partition crossed the threshold
+func (m *C) checkThreshold(p1 uint16, id uint64, old, v uint16) {
+	if m.onThreshold != nil && old < m.threshold && v >= m.threshold {
+		m.onThreshold(uint64(p1)<<idSize|id, v)
+	}
 }
 
-func (m *C) add(p1 uint16, id uint64, v uint16) {
+// add adds v to the id in the given partition and returns the new value
+func (m *C) add(p1 uint16, id uint64, v uint16) uint16 {
 	for i := range m.arr[p1] {
 		if id == m.arr[p1][i]&idBits {
 			v64 := m.arr[p1][i]>>idSize + uint64(v)
 			m.arr[p1][i] = v64<<idSize | id
-			return
+			m.checkThreshold(p1, id, uint16(v64-uint64(v)), uint16(v64))
+			return uint16(v64)
 		}
 	}
 	m.arr[p1] = append(m.arr[p1], id+uint64(v)<<idSize)
+	m.checkThreshold(p1, id, 0, v)
+	return v
+}
+
+// incr is a specialized add for the common case of adding 1. Since the count
+// is stored in the upper bits we can add directly without shifting.
+func (m *C) incr(p1 uint16, id uint64) {
+	for i := range m.arr[p1] {
+		if id == m.arr[p1][i]&idBits {
+			m.arr[p1][i] += 1 << idSize
+			if m.onThreshold != nil {
+				v := uint16(m.arr[p1][i] >> idSize)
+				m.checkThreshold(p1, id, v-1, v)
+			}
+			return
+		}
+	}
+	m.arr[p1] = append(m.arr[p1], id|1<<idSize)
+	m.checkThreshold(p1, id, 0, 1)
 }
 
 // Add adds the value to the given bytes
 func (m *C) Add(b []byte, v uint16) {
-	p1, id := m.loc(m.Key(b))
+	m.AddKey(m.Key(b), v)
+}
+
+// AddKey takes a key rather than bytes but otherwise behaves like Add
+func (m *C) AddKey(k uint64, v uint16) {
+	p1, id := m.loc(k)
 	m.add(p1, id, v)
 }
 
+// AddString adds the value to the given string. See KeyString for how the
+// string is hashed.
+func (m *C) AddString(s string, v uint16) {
+	m.AddKey(m.KeyString(s), v)
+}
+
+// IncrementAndGet adds the value to the given bytes and returns the new value
+func (m *C) IncrementAndGet(b []byte, v uint16) uint16 {
+	p1, id := m.loc(m.Key(b))
+	return m.add(p1, id, v)
+}
+
+// Touch adds the given bytes with a value of 0 if they don't already exist.
+// Existing values are left alone.
+func (m *C) Touch(b []byte) {
+	m.GetOrAdd(b, 0)
+}
+
+// AddAll adds 1 to each of the given byte slices. A byte slice that appears
+// multiple times in keys is counted multiple times. Each key is added like
+// Incr, so for large batches it can be faster to compute the keys, sort them
+// and pass them to AddSortedKeys.
+func (m *C) AddAll(keys [][]byte) {
+	for _, b := range keys {
+		p1, id := m.loc(m.Key(b))
+		m.incr(p1, id)
+	}
+}
+
+// AddSortedKeys adds counts[i] to ks[i] for every index of ks. The keys
+// should be sorted in ascending order, which groups them by partition, so that
+// large groups can be merged into their partition in a single pass. Small
+// groups are added one key at a time like AddKey. Unsorted keys are still
+// counted correctly, just more slowly. It panics if ks and counts have
+// different lengths.
+func (m *C) AddSortedKeys(ks []uint64, counts []uint16) {
+	if len(ks) != len(counts) {
+		panic("hashcounter: AddSortedKeys called with mismatched lengths")
+	}
+	var run, dst []uint64
+	for i := 0; i < len(ks); {
+		p1, _ := m.loc(ks[i])
+		j := i + 1
+		for j < len(ks) && PartitionOf(ks[j]) == p1 {
+			j++
+		}
+
+		// adding each key scans the partition, which also grows as new keys
+		// are added, so only merge when that would cost more
+		if l := j - i; l*(len(m.arr[p1])+l) <= sortedMergeCutoff {
+			for ; i < j; i++ {
+				m.add(p1, ks[i]&idBits, counts[i])
+			}
+			continue
+		}
+
+		run = run[:0]
+		for ; i < j; i++ {
+			run = append(run, uint64(counts[i])<<idSize|ks[i]&idBits)
+		}
+		sortByID(run)
+		dst = m.mergeInto(p1, run, dst, addValues)
+		if m.onThreshold != nil {
+			m.checkMergedThreshold(p1, run)
+		}
+	}
+}
+
+// checkMergedThreshold calls checkThreshold for every id in run, which must be
+// sorted by id and have just been added to the partition p1 by mergeInto
+func (m *C) checkMergedThreshold(p1 uint16, run []uint64) {
+	// mergeInto leaves the partition sorted so it can be walked alongside run
+	arr := m.arr[p1]
+	var j int
+	for i := 0; i < len(run); {
+		id := run[i] & idBits
+		var added uint16
+		for ; i < len(run) && run[i]&idBits == id; i++ {
+			added += uint16(run[i] >> idSize)
+		}
+		for arr[j]&idBits < id {
+			j++
+		}
+		v := uint16(arr[j] >> idSize)
+		m.checkThreshold(p1, id, v-added, v)
+	}
+}
+
+// Incr adds 1 to the given bytes. It's equivalent to Add(b, 1).
+func (m *C) Incr(b []byte) {
+	m.IncrKey(m.Key(b))
+}
+
+// IncrKey takes a key rather than bytes but otherwise behaves like Incr
+func (m *C) IncrKey(k uint64) {
+	p1, id := m.loc(k)
+	m.incr(p1, id)
+}
+
+// set sets the id in the given partition to v and returns the previous value
+// and a boolean if it existed
+func (m *C) set(p1 uint16, id uint64, v uint16) (uint16, bool) {
+	for i := range m.arr[p1] {
+		if id == m.arr[p1][i]&idBits {
+			old := uint16(m.arr[p1][i] >> idSize)
+			m.arr[p1][i] = uint64(v)<<idSize | id
+			return old, true
+		}
+	}
+	m.arr[p1] = append(m.arr[p1], id+uint64(v)<<idSize)
+	return 0, false
+}
+
+// Set sets the value of the given bytes, overwriting any existing value
+func (m *C) Set(b []byte, v uint16) {
+	m.SetKey(m.Key(b), v)
+}
+
+// SetKey takes a key rather than bytes but otherwise behaves like Set
+func (m *C) SetKey(k uint64, v uint16) {
+	p1, id := m.loc(k)
+	m.set(p1, id, v)
+}
+
+// GetSet sets the value of the given bytes and returns the previous value and
+// a boolean if it existed
+func (m *C) GetSet(b []byte, v uint16) (uint16, bool) {
+	p1, id := m.loc(m.Key(b))
+	return m.set(p1, id, v)
+}
+
+// CompareAndSwapKey sets the value of the given key to new if its current
+// value is old and returns true if the swap happened. If the key doesn't exist
+// then nothing is changed and false is returned.
+//
+// Unlike the functions in sync/atomic this is a plain read followed by a
+// write, so it saves a second scan of the partition but doesn't make C safe
+// for concurrent use. Adding a key can reallocate its partition and GetKey
+// reads entries without synchronization, so readers and the writer still
+// need to be synchronized, e.g. with a sync.RWMutex.
+func (m *C) CompareAndSwapKey(k uint64, old, new uint16) bool {
+	p1, id := m.loc(k)
+	for i := range m.arr[p1] {
+		if id == m.arr[p1][i]&idBits {
+			if uint16(m.arr[p1][i]>>idSize) != old {
+				return false
+			}
+			m.arr[p1][i] = uint64(new)<<idSize | id
+			return true
+		}
+	}
+	return false
+}
+
 // Get returns the value of the given bytes and a boolean if it was found
 func (m *C) Get(b []byte) (uint16, bool) {
 	return m.GetKey(m.Key(b))
@@ -91,6 +339,20 @@ func (m *C) GetKey(k uint64) (uint16, bool) {
 	return 0, false
 }
 
+// GetOrAdd returns the value of the given bytes and true if it was found.
+// Otherwise def is added for the given bytes and def and false are returned.
+func (m *C) GetOrAdd(b []byte, def uint16) (uint16, bool) {
+	p1, id := m.loc(m.Key(b))
+	for i := range m.arr[p1] {
+		if id == m.arr[p1][i]&idBits {
+			return uint16(m.arr[p1][i] >> idSize), true
+		}
+	}
+	m.arr[p1] = append(m.arr[p1], id+uint64(def)<<idSize)
+	m.checkThreshold(p1, id, 0, def)
+	return def, false
+}
+
 // Range calls the given function for every value in the map and continues
 // looping until the given bool. The returned key is going to be the result
 // of Key(bytes). If you want the key to be reversable, you must pass a hash
@@ -107,6 +369,18 @@ func (m *C) Range(f func(key uint64, value uint16) bool) {
 	}
 }
 
+// RangeErr calls the given function for every value in the map like Range
+// but stops looping the first time the function returns an error and returns
+// that error
+func (m *C) RangeErr(f func(key uint64, value uint16) error) error {
+	var err error
+	m.Range(func(key uint64, value uint16) bool {
+		err = f(key, value)
+		return err == nil
+	})
+	return err
+}
+
 // Len returns a count of all of the keys
 func (m *C) Len() int {
 	l := 0
@@ -123,62 +397,392 @@ func (m *C) Reset() {
 	}
 }
 
-// MarshalBinary implements the encoding.BinaryMarshaler interface.
+// truncate removes all of the keys like Reset but keeps each partition's
+// capacity so it can be reused
+func (m *C) truncate() {
+	for p1 := range m.arr {
+		m.arr[p1] = m.arr[p1][:0]
+	}
+}
+
+// uvarintLen returns the number of bytes binary.PutUvarint would use for x
+func uvarintLen(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// The versions of the binary encoding. Both versions are followed by each
+// non-empty partition as its uint16 index, a uvarint of its length and then
+// each of its entries as a uint64. Version 2 also records the number of
+// partitions after the version so that the end of an encoding is known and
+// multiple encodings can be concatenated.
+const (
+	version1 = 1
+	version2 = 2
+)
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface. It uses
+// version 1 of the encoding so that it can be read by every release of this
+// package. Use MarshalBinaryConcat for an encoding that can be concatenated.
 func (m *C) MarshalBinary() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	buf.Write([]byte{1}) // version
-	b := make([]byte, binary.MaxVarintLen64)
+	return m.marshal(version1), nil
+}
+
+// MarshalBinaryConcat behaves like MarshalBinary except that it uses version 2
+// of the encoding. The results of multiple calls to MarshalBinaryConcat can be
+// concatenated and passed to UnmarshalBinary to get the merged C. Releases of
+// this package before version 2 was added can't decode it.
+func (m *C) MarshalBinaryConcat() ([]byte, error) {
+	return m.marshal(version2), nil
+}
+
+// marshal encodes m using the given version
+func (m *C) marshal(version byte) []byte {
+	// compute the size up front so we only allocate once
+	var parts, size int
+	for p1 := range m.arr {
+		l := len(m.arr[p1])
+		if l < 1 {
+			continue
+		}
+		parts++
+		size += 2 + uvarintLen(uint64(l)) + l*8
+	}
+	size++
+	if version == version2 {
+		size += uvarintLen(uint64(parts))
+	}
+
+	b := make([]byte, size)
+	b[0] = version
+	off := 1
+	if version == version2 {
+		off += binary.PutUvarint(b[off:], uint64(parts))
+	}
 	for p1 := range m.arr {
 		l := len(m.arr[p1])
 		if l < 1 {
 			continue
 		}
 		// if part1Size changes then we'll need to change this
-		binary.BigEndian.PutUint16(b, uint16(p1))
-		buf.Write(b[:2])
+		binary.BigEndian.PutUint16(b[off:], uint16(p1))
+		off += 2
 
-		i := binary.PutUvarint(b, uint64(l))
-		buf.Write(b[:i])
+		off += binary.PutUvarint(b[off:], uint64(l))
 
 		for _, idv := range m.arr[p1] {
-			binary.BigEndian.PutUint64(b, idv)
-			buf.Write(b[:8])
+			binary.BigEndian.PutUint64(b[off:], idv)
+			off += 8
+		}
+	}
+	return b
+}
+
+// readHeader reads the header of an encoding from the start of b and returns
+// the length of the header and the number of partitions that follow it, which
+// is -1 if the partitions continue until the end of b
+func readHeader(b []byte) (int, int, error) {
+	if len(b) < 1 {
+		return 0, 0, errors.New("empty byte slice")
+	}
+	switch b[0] {
+	case version1:
+		return 1, -1, nil
+	case version2:
+		parts, res := binary.Uvarint(b[1:])
+		if res < 1 {
+			return 0, 0, fmt.Errorf("error reading partition count with Uvarint: %d", res)
+		}
+		if parts > 1<<part1Size {
+			return 0, 0, fmt.Errorf("invalid partition count: %d", parts)
+		}
+		return 1 + res, int(parts), nil
+	default:
+		return 0, 0, fmt.Errorf("unexpected version: %d", b[0])
+	}
+}
+
+// readPartition reads the header of the partition at the start of b and
+// returns its index, its number of entries and the length of the header. An
+// error is returned if b doesn't hold all of the partition's entries.
+func readPartition(b []byte) (uint16, int, int, error) {
+	if len(b) < 2 {
+		return 0, 0, 0, errors.New("truncated partition header")
+	}
+	p1 := binary.BigEndian.Uint16(b)
+
+	l, res := binary.Uvarint(b[2:])
+	if res < 1 {
+		return 0, 0, 0, fmt.Errorf("error reading length with Uvarint: %d", res)
+	}
+
+	if l > uint64(len(b)-2-res)/8 {
+		return 0, 0, 0, fmt.Errorf("truncated partition %d: expected %d entries", p1, l)
+	}
+	return p1, int(l), 2 + res, nil
+}
+
+// scanBinary walks the encodings in b and returns the total number of entries
+// and the length of b that holds complete partitions. If b is truncated or
+// malformed then an error is returned along with the totals for the
+// partitions before the problem.
+func scanBinary(b []byte) (int, int, error) {
+	var total, n int
+	for n < len(b) {
+		hdr, parts, err := readHeader(b[n:])
+		if err != nil {
+			return total, n, err
+		}
+		n += hdr
+		// partitions are always encoded in ascending order, which also
+		// catches most encodings that were appended after a version 1 one
+		last := -1
+		for i := 0; i < parts || (parts < 0 && n < len(b)); i++ {
+			p1, l, phdr, err := readPartition(b[n:])
+			if err != nil {
+				return total, n, err
+			}
+			if int(p1) <= last {
+				return total, n, fmt.Errorf("partition %d out of order after %d", p1, last)
+			}
+			last = int(p1)
+			n += phdr + l*8
+			total += l
 		}
 	}
-	return buf.Bytes(), nil
+	return total, n, nil
 }
 
-// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// decodeBinary decodes the encodings in b, which must have already been
+// validated by scanBinary, and which contain total entries. The first time a
+// partition is decoded it replaces the one on m and any later occurrences of
+// it are merged in, so the result doesn't depend on the order of encodings.
+func (m *C) decodeBinary(b []byte, total int) {
+	all := make([]uint64, total)
+	// seen has a bit set for every partition decoded so far
+	var seen [(1 << part1Size) / 64]uint64
+	for len(b) > 0 {
+		hdr, parts, _ := readHeader(b)
+		b = b[hdr:]
+		for i := 0; len(b) > 0 && (parts < 0 || i < parts); i++ {
+			p1, l, phdr, _ := readPartition(b)
+			b = b[phdr:]
+
+			// limit the capacity so an append to one partition can't
+			// overwrite the next one
+			arr := all[:l:l]
+			all = all[l:]
+			for j := range arr {
+				arr[j] = binary.BigEndian.Uint64(b)
+				b = b[8:]
+			}
+
+			if seen[p1/64]&(1<<(p1%64)) == 0 || len(m.arr[p1]) == 0 {
+				seen[p1/64] |= 1 << (p1 % 64)
+				m.arr[p1] = arr
+				continue
+			}
+			sortByID(m.arr[p1])
+			sortByID(arr)
+			dst := make([]uint64, 0, len(m.arr[p1])+len(arr))
+			m.arr[p1] = mergeSorted(dst, m.arr[p1], arr, addValues)
+		}
+	}
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface and
+// decodes the result of either MarshalBinary or MarshalBinaryConcat. If b
+// holds multiple concatenated encodings then the first is decoded and every
+// one after it is merged in as if by Merge. Any partitions already on m that
+// appear in b are replaced, no matter which encoding they appear in.
+//
+// Only MarshalBinaryConcat encodings can be followed by another encoding. A
+// MarshalBinary encoding has no length so everything after it is read as more
+// of its partitions. That usually results in an error but isn't guaranteed
+// to, so a MarshalBinary encoding must only ever be the last one in b.
 func (m *C) UnmarshalBinary(b []byte) error {
 	if len(b) < 1 {
 		return errors.New("empty byte slice")
 	}
-	if b[0] != 1 {
-		return fmt.Errorf("unexpected version: %d", b[0])
+
+	// validate and count everything first so that all of the partitions can
+	// share a single allocation
+	total, _, err := scanBinary(b)
+	if err != nil {
+		return err
+	}
+	m.decodeBinary(b, total)
+	return nil
+}
+
+// UnmarshalBinaryPartial behaves like UnmarshalBinary except that if b is
+// truncated or corrupted then every partition before the problem is still
+// decoded. It returns the number of entries that were decoded along with any
+// error that stopped decoding early.
+func (m *C) UnmarshalBinaryPartial(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, errors.New("empty byte slice")
 	}
-	b = b[1:]
-	for len(b) > 0 {
-		p1 := binary.BigEndian.Uint16(b)
-		b = b[2:]
 
-		l, res := binary.Uvarint(b)
-		if res < 1 {
-			return fmt.Errorf("error reading length with Uvarint: %d", res)
+	total, n, err := scanBinary(b)
+	m.decodeBinary(b[:n], total)
+	return total, err
+}
+
+// Migrate decodes b, which can be in any supported version, and returns it
+// encoded using the given version. Concatenated encodings are merged like
+// they are by UnmarshalBinary. Migrating to version 1 allows older readers to
+// decode the result, but version 1 encodings can't be concatenated.
+func Migrate(b []byte, version int) ([]byte, error) {
+	if version != version1 && version != version2 {
+		return nil, fmt.Errorf("unsupported version: %d", version)
+	}
+	m := new(C)
+	if err := m.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return m.marshal(byte(version)), nil
+}
+
+// isSortedByID returns true if the entries are sorted by id
+func isSortedByID(a []uint64) bool {
+	for i := 1; i < len(a); i++ {
+		if a[i]&idBits < a[i-1]&idBits {
+			return false
 		}
-		b = b[res:]
+	}
+	return true
+}
+
+// sortByID sorts the entries by id unless they're already sorted. Rotating
+// each entry moves the id into the upper bits so the entries can be sorted as
+// plain integers, which is much faster than sorting with a comparison func.
+func sortByID(a []uint64) {
+	if isSortedByID(a) {
+		return
+	}
+	for i := range a {
+		a[i] = bits.RotateLeft64(a[i], 64-idSize)
+	}
+	slices.Sort(a)
+	for i := range a {
+		a[i] = bits.RotateLeft64(a[i], idSize-64)
+	}
+}
 
-		m.arr[p1] = make([]uint64, l)
-		for i := range m.arr[p1] {
-			m.arr[p1][i] = binary.BigEndian.Uint64(b)
-			b = b[8:]
+// mergeCutoff is the product of the lengths of two partitions below which
+// it's faster to scan the partition for each entry being added than to sort
+// both partitions and merge them
+const mergeCutoff = 1 << 16
+
+// sortedMergeCutoff is like mergeCutoff but for entries that are already
+// sorted, which are cheaper to merge since they don't need to be sorted first
+const sortedMergeCutoff = 1 << 14
+
+// mergeInto merges the entries in b, which must be sorted by id, into
+// partition p1, combining the values of ids that exist on both using fn. dst
+// is used as scratch space and is returned so it can be reused.
+func (m *C) mergeInto(p1 uint16, b, dst []uint64, fn func(x, y uint16) uint16) []uint64 {
+	sortByID(m.arr[p1])
+	dst = mergeSorted(dst[:0], m.arr[p1], b, fn)
+	if cap(m.arr[p1]) >= len(dst) {
+		m.arr[p1] = m.arr[p1][:len(dst)]
+		copy(m.arr[p1], dst)
+	} else {
+		m.arr[p1] = append(m.arr[p1][:0], dst...)
+	}
+	return dst
+}
+
+// index returns the index of the id in the given partition or -1 if it
+// doesn't exist
+func (m *C) index(p1 uint16, id uint64) int {
+	for i, idv := range m.arr[p1] {
+		if id == idv&idBits {
+			return i
 		}
 	}
-	return nil
+	return -1
+}
+
+// combine combines v with the value of the id in the given partition using fn,
+// adding the id with a value of v if it doesn't exist
+func (m *C) combine(p1 uint16, id uint64, v uint16, fn func(x, y uint16) uint16) {
+	i := m.index(p1, id)
+	if i < 0 {
+		m.arr[p1] = append(m.arr[p1], id+uint64(v)<<idSize)
+		return
+	}
+	v = fn(uint16(m.arr[p1][i]>>idSize), v)
+	m.arr[p1][i] = uint64(v)<<idSize | id
+}
+
+// combine functions that are passed to mergeSorted to decide the value of an
+// id that exists on both sides
+func addValues(x, y uint16) uint16 { return x + y }
+
+func maxValues(x, y uint16) uint16 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func minValues(x, y uint16) uint16 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// mergeSorted appends the entries from a and b, which must both be sorted by
+// id, to dst in sorted order and returns the result. The values of entries
+// with the same id are combined using fn.
+func mergeSorted(dst, a, b []uint64, fn func(x, y uint16) uint16) []uint64 {
+	var idv uint64
+	for len(a) > 0 || len(b) > 0 {
+		if len(b) == 0 || (len(a) > 0 && a[0]&idBits <= b[0]&idBits) {
+			idv, a = a[0], a[1:]
+		} else {
+			idv, b = b[0], b[1:]
+		}
+		if l := len(dst); l > 0 && dst[l-1]&idBits == idv&idBits {
+			v := fn(uint16(dst[l-1]>>idSize), uint16(idv>>idSize))
+			dst[l-1] = uint64(v)<<idSize | idv&idBits
+			continue
+		}
+		dst = append(dst, idv)
+	}
+	return dst
 }
 
 // Merge adds every key from the sent C to the called on C. This assumes the
-// hash functions are the same.
+// hash functions are the same. Large partitions that exist on both are sorted
+// and merged in a single pass, which leaves those partitions on m sorted.
 func (m *C) Merge(n *C) {
+	m.merge(n, addValues)
+}
+
+// MergeMax behaves like Merge except that the value of a key that exists on
+// both is the larger of the two values rather than their sum. Keys that only
+// exist on one keep their value.
+func (m *C) MergeMax(n *C) {
+	m.merge(n, maxValues)
+}
+
+// MergeMin behaves like Merge except that the value of a key that exists on
+// both is the smaller of the two values rather than their sum. Keys that only
+// exist on one keep their value.
+func (m *C) MergeMin(n *C) {
+	m.merge(n, minValues)
+}
+
+func (m *C) merge(n *C, fn func(x, y uint16) uint16) {
+	var scratch, dst []uint64
 	for p1 := range n.arr {
 		if len(n.arr[p1]) < 1 {
 			continue
@@ -191,9 +795,22 @@ func (m *C) Merge(n *C) {
 			continue
 		}
 
-		// otherwise loop over each n value and add it to m
-		for _, idv := range n.arr[p1] {
-			m.add(uint16(p1), idv&idBits, uint16(idv>>idSize))
+		// small partitions are faster to loop over and add to m directly
+		if len(m.arr[p1])*len(n.arr[p1]) <= mergeCutoff {
+			for _, idv := range n.arr[p1] {
+				m.combine(uint16(p1), idv&idBits, uint16(idv>>idSize), fn)
+			}
+			continue
+		}
+
+		// otherwise sort both sides and merge them, sorting a copy of n so
+		// it isn't modified
+		nb := n.arr[p1]
+		if !isSortedByID(nb) {
+			scratch = append(scratch[:0], nb...)
+			sortByID(scratch)
+			nb = scratch
 		}
+		dst = m.mergeInto(uint16(p1), nb, dst, fn)
 	}
 }
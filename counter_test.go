@@ -1,9 +1,13 @@
 package hashcounter
 
 import (
+	"errors"
 	"math/rand"
+	"sort"
+	"strconv"
 	"testing"
 
+	"github.com/cespare/xxhash"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -39,6 +43,327 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestAddAll(t *testing.T) {
+	c2 := new(C)
+	c2.AddAll([][]byte{[]byte(`a`), []byte(`b`), []byte(`a`)})
+	assert.Equal(t, 2, c2.Len())
+
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(2), v)
+
+	v, ok = c2.Get([]byte(`b`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+}
+
+func TestAddKey(t *testing.T) {
+	c2 := new(C)
+	k := c2.Key([]byte(`a`))
+	c2.AddKey(k, 2)
+	c2.Add([]byte(`a`), 3)
+
+	v, ok := c2.GetKey(k)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(5), v)
+	assert.Equal(t, 1, c2.Len())
+}
+
+func TestAddString(t *testing.T) {
+	c2 := new(C)
+	c2.AddString(`a`, 2)
+	c2.Add([]byte(`a`), 3)
+	assert.Equal(t, c2.Key([]byte(`a`)), c2.KeyString(`a`))
+
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(5), v)
+
+	c3 := NewWithHash(func(b []byte) uint64 { return uint64(len(b)) })
+	assert.Equal(t, uint64(3), c3.KeyString(`abc`))
+}
+
+func TestAddAllocs(t *testing.T) {
+	b := []byte(`hello`)
+	s := `world`
+	fn := func(b []byte) uint64 { return xxhash.Sum64(b) }
+	fnString := func(s string) uint64 { return xxhash.Sum64String(s) }
+	for _, c2 := range []*C{new(C), NewWithHashes(fn, fnString)} {
+		// the first add may grow the bucket so get that out of the way
+		c2.Add(b, 1)
+		c2.AddString(s, 1)
+		k := c2.Key(b)
+		assert.Zero(t, testing.AllocsPerRun(100, func() { c2.Add(b, 1) }))
+		assert.Zero(t, testing.AllocsPerRun(100, func() { c2.AddKey(k, 1) }))
+		assert.Zero(t, testing.AllocsPerRun(100, func() { c2.Incr(b) }))
+		assert.Zero(t, testing.AllocsPerRun(100, func() { c2.IncrKey(k) }))
+		assert.Zero(t, testing.AllocsPerRun(100, func() { c2.AddString(s, 1) }))
+	}
+
+	// without a string hash function the bytes hash is still used
+	c2 := NewWithHash(fn)
+	assert.Equal(t, c2.Key([]byte(s)), c2.KeyString(s))
+}
+
+func TestAddSortedKeys(t *testing.T) {
+	mkeys := map[uint64]uint16{}
+	for k, v := range m {
+		mkeys[c.Key([]byte(k))] = v
+	}
+	ks := make([]uint64, 0, len(mkeys))
+	for k := range mkeys {
+		ks = append(ks, k)
+	}
+	sort.Slice(ks, func(i, j int) bool { return ks[i] < ks[j] })
+	counts := make([]uint16, len(ks))
+	for i, k := range ks {
+		counts[i] = mkeys[k]
+	}
+
+	c2 := new(C)
+	c2.AddSortedKeys(ks, counts)
+	// a second time with a duplicate and out of order key at the front
+	ks2 := append([]uint64{ks[1], ks[0], ks[0]}, ks[2:]...)
+	counts2 := append([]uint16{counts[1], counts[0], 0}, counts[2:]...)
+	c2.AddSortedKeys(ks2, counts2)
+	require.Equal(t, len(ks), c2.Len())
+	for k, v := range mkeys {
+		v2, ok := c2.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, 2*v, v2)
+	}
+
+	assert.Panics(t, func() { c2.AddSortedKeys(ks, nil) })
+
+	// enough keys in a single partition to be merged rather than added one
+	// at a time, including a duplicate and an out of order key
+	c3 := new(C)
+	c3.AddKey(5, 1)
+	ks = ks[:0]
+	counts = counts[:0]
+	for i := uint64(0); i < 1000; i++ {
+		ks = append(ks, i)
+		counts = append(counts, 1)
+	}
+	ks = append(ks, 5, 3)
+	counts = append(counts, 2, 1)
+	c3.AddSortedKeys(ks, counts)
+	require.Equal(t, 1000, c3.Len())
+	for i := uint64(0); i < 1000; i++ {
+		exp := uint16(1)
+		switch i {
+		case 5:
+			exp = 4
+		case 3:
+			exp = 2
+		}
+		v, ok := c3.GetKey(i)
+		require.True(t, ok)
+		assert.Equal(t, exp, v)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	c2 := new(C)
+	c2.Incr([]byte(`a`))
+	c2.Incr([]byte(`a`))
+	c2.IncrKey(c2.Key([]byte(`b`)))
+	assert.Equal(t, 2, c2.Len())
+
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(2), v)
+
+	v, ok = c2.Get([]byte(`b`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+
+	// make sure it wraps the same way Add does
+	c2.Add([]byte(`c`), 1<<16-1)
+	c2.Incr([]byte(`c`))
+	v, ok = c2.Get([]byte(`c`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0), v)
+	v, ok = c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(2), v)
+}
+
+func TestIncrementAndGet(t *testing.T) {
+	c2 := new(C)
+	assert.Equal(t, uint16(2), c2.IncrementAndGet([]byte(`a`), 2))
+	assert.Equal(t, uint16(5), c2.IncrementAndGet([]byte(`a`), 3))
+
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(5), v)
+
+	// make sure it wraps the same way Add does
+	assert.Equal(t, uint16(4), c2.IncrementAndGet([]byte(`a`), 1<<16-1))
+}
+
+func TestSet(t *testing.T) {
+	c2 := new(C)
+	c2.Add([]byte(`a`), 5)
+	c2.Set([]byte(`a`), 2)
+	c2.SetKey(c2.Key([]byte(`b`)), 7)
+
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(2), v)
+
+	v, ok = c2.Get([]byte(`b`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(7), v)
+	assert.Equal(t, 2, c2.Len())
+}
+
+func TestGetSet(t *testing.T) {
+	c2 := new(C)
+	old, ok := c2.GetSet([]byte(`a`), 3)
+	assert.False(t, ok)
+	assert.Equal(t, uint16(0), old)
+
+	old, ok = c2.GetSet([]byte(`a`), 1)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(3), old)
+
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+	assert.Equal(t, 1, c2.Len())
+}
+
+func TestCompareAndSwapKey(t *testing.T) {
+	c2 := new(C)
+	k := c2.Key([]byte(`a`))
+	assert.False(t, c2.CompareAndSwapKey(k, 0, 1))
+	assert.Equal(t, 0, c2.Len())
+
+	c2.AddKey(k, 2)
+	assert.False(t, c2.CompareAndSwapKey(k, 1, 5))
+	assert.True(t, c2.CompareAndSwapKey(k, 2, 5))
+
+	v, ok := c2.GetKey(k)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(5), v)
+}
+
+func TestGetOrAdd(t *testing.T) {
+	c2 := new(C)
+	v, ok := c2.GetOrAdd([]byte(`a`), 3)
+	assert.False(t, ok)
+	assert.Equal(t, uint16(3), v)
+
+	v, ok = c2.GetOrAdd([]byte(`a`), 5)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(3), v)
+
+	v, ok = c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(3), v)
+	assert.Equal(t, 1, c2.Len())
+}
+
+func TestTouch(t *testing.T) {
+	c2 := new(C)
+	c2.Touch([]byte(`a`))
+	v, ok := c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0), v)
+
+	c2.Add([]byte(`a`), 2)
+	c2.Touch([]byte(`a`))
+	v, ok = c2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(2), v)
+	assert.Equal(t, 1, c2.Len())
+}
+
+func TestWithThreshold(t *testing.T) {
+	crossed := map[uint64]uint16{}
+	calls := 0
+	c2 := New().WithThreshold(3, func(k uint64, v uint16) {
+		crossed[k] = v
+		calls++
+	})
+
+	a, b := []byte(`a`), []byte(`b`)
+	c2.Incr(a)
+	c2.Incr(a)
+	assert.Equal(t, 0, calls)
+	c2.Incr(a)
+	assert.Equal(t, map[uint64]uint16{c2.Key(a): 3}, crossed)
+	c2.Incr(a)
+	c2.Add(a, 10)
+	assert.Equal(t, 1, calls)
+
+	// skipping past the limit still counts as crossing it
+	c2.Add(b, 1)
+	c2.Add(b, 5)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, uint16(6), crossed[c2.Key(b)])
+
+	// adding a new key at or above the limit crosses it immediately
+	c2.AddKey(1, 3)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, uint16(3), crossed[1])
+
+	// lowering a value with Set lets it cross again
+	c2.Set(a, 1)
+	assert.Equal(t, 3, calls)
+	c2.Add(a, 2)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, uint16(3), crossed[c2.Key(a)])
+
+	// GetOrAdd only crosses when it adds
+	c2.GetOrAdd([]byte(`c`), 5)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, uint16(5), crossed[c2.Key([]byte(`c`))])
+	c2.GetOrAdd([]byte(`c`), 5)
+	c2.GetOrAdd([]byte(`d`), 2)
+	assert.Equal(t, 5, calls)
+
+	// a limit of 0 never fires
+	calls = 0
+	c3 := New().WithThreshold(0, func(k uint64, v uint16) { calls++ })
+	c3.Add(a, 1)
+	c3.Incr(a)
+	assert.Equal(t, 0, calls)
+}
+
+func TestWithThresholdAddSortedKeys(t *testing.T) {
+	// one partition with a few keys is added key by key and one with many
+	// keys is merged, and both need to fire
+	for _, n := range []uint64{3, 1000} {
+		crossed := map[uint64]uint16{}
+		c2 := New().WithThreshold(3, func(k uint64, v uint16) {
+			crossed[k] = v
+		})
+		c2.AddKey(0, 2)
+		c2.AddKey(1, 4)
+		delete(crossed, 1)
+
+		ks := []uint64{0}
+		counts := []uint16{1}
+		for k := uint64(1); k < n; k++ {
+			ks = append(ks, k)
+			counts = append(counts, 1)
+		}
+		// key 2 crosses from a duplicate
+		ks = append(ks, 2, 2)
+		counts = append(counts, 1, 5)
+		c2.AddSortedKeys(ks, counts)
+
+		// key 1 was already past the limit
+		assert.Equal(t, map[uint64]uint16{0: 3, 2: 7}, crossed, "n=%d", n)
+
+		// and they don't fire again
+		c2.AddSortedKeys(ks, counts)
+		assert.Len(t, crossed, 2)
+	}
+}
+
 func TestRange(t *testing.T) {
 	mkeys := map[uint64]uint16{}
 	for k, v := range m {
@@ -53,6 +378,28 @@ func TestRange(t *testing.T) {
 	assert.Equal(t, c.Len(), l)
 }
 
+func TestRangeErr(t *testing.T) {
+	l := 0
+	err := c.RangeErr(func(k uint64, v uint16) error {
+		l++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, c.Len(), l)
+
+	l = 0
+	expErr := errors.New("stop")
+	err = c.RangeErr(func(k uint64, v uint16) error {
+		l++
+		if l == 10 {
+			return expErr
+		}
+		return nil
+	})
+	assert.Equal(t, expErr, err)
+	assert.Equal(t, 10, l)
+}
+
 func TestMarshalUnmarshal(t *testing.T) {
 	b, err := c.MarshalBinary()
 	require.NoError(t, err)
@@ -70,6 +417,217 @@ func TestMarshalUnmarshal(t *testing.T) {
 	})
 }
 
+func TestUnmarshalVersion1(t *testing.T) {
+	// the upper 16 bits of each entry are the value and the rest is the id
+	b := []byte{version1}
+	b = append(b, 0, 1, 2)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 1)
+	b = append(b, 0, 2, 0, 0, 0, 0, 0, 2)
+	b = append(b, 1, 0, 1)
+	b = append(b, 0, 3, 0, 0, 0, 0, 0, 3)
+
+	c2 := new(C)
+	require.NoError(t, c2.UnmarshalBinary(b))
+	assert.Equal(t, 3, c2.Len())
+	for k, v := range map[uint64]uint16{1<<48 | 1: 0, 1<<48 | 2: 2, 1<<56 | 3: 3} {
+		v2, ok := c2.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+	}
+}
+
+func TestUnmarshalConcatenated(t *testing.T) {
+	b, err := c.MarshalBinaryConcat()
+	require.NoError(t, err)
+
+	c2 := new(C)
+	c2.Add([]byte(`a`), 1)
+	c2.Add([]byte(`b`), 2)
+	b2, err := c2.MarshalBinaryConcat()
+	require.NoError(t, err)
+
+	c3 := new(C)
+	require.NoError(t, c3.UnmarshalBinary(append(append(append([]byte{}, b...), b2...), b...)))
+
+	exp := new(C)
+	exp.Merge(c)
+	exp.Merge(c2)
+	exp.Merge(c)
+	require.Equal(t, exp.Len(), c3.Len())
+	exp.Range(func(k uint64, v uint16) bool {
+		v2, ok := c3.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+		return true
+	})
+
+	// an empty C can be concatenated too
+	b3, err := new(C).MarshalBinaryConcat()
+	require.NoError(t, err)
+	c3 = new(C)
+	require.NoError(t, c3.UnmarshalBinary(append(append([]byte{}, b3...), b2...)))
+	assert.Equal(t, c2.Len(), c3.Len())
+}
+
+func TestUnmarshalConcatenatedNonEmpty(t *testing.T) {
+	k1, k2 := uint64(1<<48|1), uint64(2<<48|1)
+	c1 := new(C)
+	c1.AddKey(k1, 1)
+	b1, err := c1.MarshalBinaryConcat()
+	require.NoError(t, err)
+	c2 := new(C)
+	c2.AddKey(k2, 2)
+	b2, err := c2.MarshalBinaryConcat()
+	require.NoError(t, err)
+
+	// a partition is replaced no matter which encoding it's in
+	for _, b := range [][]byte{
+		append(append([]byte{}, b1...), b2...),
+		append(append([]byte{}, b2...), b1...),
+	} {
+		c3 := new(C)
+		c3.AddKey(k1, 10)
+		c3.AddKey(k2, 20)
+		require.NoError(t, c3.UnmarshalBinary(b))
+		v, _ := c3.GetKey(k1)
+		assert.Equal(t, uint16(1), v)
+		v, _ = c3.GetKey(k2)
+		assert.Equal(t, uint16(2), v)
+	}
+}
+
+func TestUnmarshalAfterVersion1(t *testing.T) {
+	b1, err := c.MarshalBinary()
+	require.NoError(t, err)
+	b2, err := c.MarshalBinaryConcat()
+	require.NoError(t, err)
+
+	// a version 1 encoding can be last
+	c2 := new(C)
+	require.NoError(t, c2.UnmarshalBinary(append(append([]byte{}, b2...), b1...)))
+	assert.Equal(t, c.Len(), c2.Len())
+
+	// but anything after it is misread as more partitions
+	c2 = new(C)
+	assert.Error(t, c2.UnmarshalBinary(append(append([]byte{}, b1...), b2...)))
+	assert.Equal(t, 0, c2.Len())
+}
+
+func TestUnmarshalOutOfOrder(t *testing.T) {
+	b := []byte{version1}
+	b = append(b, 0, 2, 1)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 1)
+	b = append(b, 0, 1, 1)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 1)
+	assert.Error(t, new(C).UnmarshalBinary(b))
+}
+
+func TestMigrate(t *testing.T) {
+	b, err := c.MarshalBinary()
+	require.NoError(t, err)
+
+	b2, err := Migrate(b, version2)
+	require.NoError(t, err)
+	assert.Equal(t, byte(version2), b2[0])
+	bc, err := c.MarshalBinaryConcat()
+	require.NoError(t, err)
+	assert.Equal(t, bc, b2)
+
+	b1, err := Migrate(b2, version1)
+	require.NoError(t, err)
+	assert.Equal(t, b, b1)
+
+	for _, b := range [][]byte{b1, b2} {
+		c2 := new(C)
+		require.NoError(t, c2.UnmarshalBinary(b))
+		require.Equal(t, c.Len(), c2.Len())
+		c.Range(func(k uint64, v uint16) bool {
+			v2, ok := c2.GetKey(k)
+			require.True(t, ok)
+			assert.Equal(t, v, v2)
+			return true
+		})
+	}
+
+	_, err = Migrate(b, 3)
+	assert.Error(t, err)
+	_, err = Migrate(b[:len(b)-1], version1)
+	assert.Error(t, err)
+}
+
+func TestUnmarshalAllocs(t *testing.T) {
+	b, err := c.MarshalBinary()
+	require.NoError(t, err)
+
+	c2 := new(C)
+	// the C itself is the first allocation and the entries are the second
+	assert.Equal(t, float64(2), testing.AllocsPerRun(10, func() {
+		c2 = new(C)
+		c2.UnmarshalBinary(b)
+	}))
+	require.Equal(t, c.Len(), c2.Len())
+
+	// appending to one partition must not clobber the next one
+	c2.Range(func(k uint64, v uint16) bool {
+		c2.AddKey(k+1, 1)
+		return false
+	})
+	c.Range(func(k uint64, v uint16) bool {
+		v2, ok := c2.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+		return true
+	})
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	b, err := c.MarshalBinary()
+	require.NoError(t, err)
+
+	for _, l := range []int{2, 3, len(b) - 1} {
+		c2 := new(C)
+		assert.Error(t, c2.UnmarshalBinary(b[:l]))
+		assert.Equal(t, 0, c2.Len())
+	}
+}
+
+func TestUnmarshalBinaryPartial(t *testing.T) {
+	b, err := c.MarshalBinary()
+	require.NoError(t, err)
+
+	c2 := new(C)
+	n, err := c2.UnmarshalBinaryPartial(b)
+	require.NoError(t, err)
+	assert.Equal(t, c.Len(), n)
+	assert.Equal(t, c.Len(), c2.Len())
+
+	// cut off the last entry so the last partition is lost
+	c2 = new(C)
+	n, err = c2.UnmarshalBinaryPartial(b[:len(b)-8])
+	assert.Error(t, err)
+	assert.Equal(t, c2.Len(), n)
+	assert.True(t, n > 0 && n < c.Len())
+	c2.Range(func(k uint64, v uint16) bool {
+		v2, ok := c.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v2, v)
+		return true
+	})
+
+	// a corrupted length shouldn't panic
+	c2 = new(C)
+	n, err = c2.UnmarshalBinaryPartial([]byte{1, 0, 0, 0xff})
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = c2.UnmarshalBinaryPartial(nil)
+	assert.Error(t, err)
+}
+
+func TestMarshalAllocs(t *testing.T) {
+	assert.Equal(t, float64(1), testing.AllocsPerRun(10, func() { c.MarshalBinary() }))
+}
+
 func TestMerge(t *testing.T) {
 	c2 := new(C)
 	m2 := map[string]uint16{}
@@ -98,6 +656,126 @@ func TestMerge(t *testing.T) {
 	}
 }
 
+func TestMergeOverlap(t *testing.T) {
+	c2 := new(C)
+	c2.Merge(c)
+	c2.Merge(c)
+	require.Equal(t, c.Len(), c2.Len())
+
+	c.Range(func(k uint64, v uint16) bool {
+		v2, ok := c2.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, 2*v, v2)
+		return true
+	})
+
+	// entries in the same partition with differing ids and overflowing values
+	a, b := new(C), new(C)
+	a.AddKey(3, 1)
+	a.AddKey(1, 1<<16-1)
+	b.AddKey(2, 1)
+	b.AddKey(1, 2)
+	b.AddKey(0, 4)
+	a.Merge(b)
+	assert.Equal(t, 4, a.Len())
+	for k, v := range map[uint64]uint16{0: 4, 1: 1, 2: 1, 3: 1} {
+		v2, ok := a.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+	}
+}
+
+func TestMergeLargePartitions(t *testing.T) {
+	// put enough keys in one partition to sort and merge it rather than
+	// scanning, with an overlapping half
+	a, b := new(C), new(C)
+	exp := map[uint64][3]uint16{} // sum, max, min
+	for i := uint64(0); i < 1000; i++ {
+		a.AddKey(i*7, uint16(i))
+		exp[i*7] = [3]uint16{uint16(i), uint16(i), uint16(i)}
+	}
+	for i := uint64(500); i < 1500; i++ {
+		k := i * 7
+		b.AddKey(k, 3)
+		e, ok := exp[k]
+		if !ok {
+			exp[k] = [3]uint16{3, 3, 3}
+			continue
+		}
+		exp[k] = [3]uint16{e[0] + 3, maxValues(e[1], 3), minValues(e[2], 3)}
+	}
+	require.True(t, len(a.arr[0])*len(b.arr[0]) > mergeCutoff)
+	// reverse b so it has to be sorted
+	for i, j := 0, len(b.arr[0])-1; i < j; i, j = i+1, j-1 {
+		b.arr[0][i], b.arr[0][j] = b.arr[0][j], b.arr[0][i]
+	}
+	orig := append([]uint64{}, b.arr[0]...)
+
+	for i, fn := range []func(*C, *C){(*C).Merge, (*C).MergeMax, (*C).MergeMin} {
+		c2 := new(C)
+		c2.Merge(a)
+		fn(c2, b)
+		require.Equal(t, len(exp), c2.Len())
+		for k, e := range exp {
+			v, ok := c2.GetKey(k)
+			require.True(t, ok)
+			assert.Equal(t, e[i], v)
+		}
+		assert.True(t, isSortedByID(c2.arr[0]))
+	}
+
+	// b is left alone
+	assert.Equal(t, orig, b.arr[0])
+}
+
+func TestSortByID(t *testing.T) {
+	a := []uint64{3<<idSize | 5, 1<<idSize | 2, 7<<idSize | 9, 0}
+	sortByID(a)
+	assert.Equal(t, []uint64{0, 1<<idSize | 2, 3<<idSize | 5, 7<<idSize | 9}, a)
+}
+
+func TestMergeMaxMin(t *testing.T) {
+	a, b := new(C), new(C)
+	a.AddKey(1, 5)
+	a.AddKey(2, 1)
+	a.AddKey(3, 4)
+	b.AddKey(1, 2)
+	b.AddKey(2, 7)
+	b.AddKey(1<<63, 3)
+
+	hi := new(C)
+	hi.Merge(a)
+	hi.MergeMax(b)
+	lo := new(C)
+	lo.Merge(a)
+	lo.MergeMin(b)
+
+	for k, v := range map[uint64]uint16{1: 5, 2: 7, 3: 4, 1 << 63: 3} {
+		v2, ok := hi.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+	}
+	for k, v := range map[uint64]uint16{1: 2, 2: 1, 3: 4, 1 << 63: 3} {
+		v2, ok := lo.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+	}
+	assert.Equal(t, 4, hi.Len())
+	assert.Equal(t, 4, lo.Len())
+
+	// merging the same C is a no-op
+	c2 := new(C)
+	c2.Merge(c)
+	c2.MergeMax(c)
+	c2.MergeMin(c)
+	c.Range(func(k uint64, v uint16) bool {
+		v2, ok := c2.GetKey(k)
+		require.True(t, ok)
+		assert.Equal(t, v, v2)
+		return true
+	})
+}
+
 func TestReset(t *testing.T) {
 	c2 := new(C)
 	c2.Merge(c)
@@ -106,6 +784,19 @@ func TestReset(t *testing.T) {
 	assert.Equal(t, 0, c2.Len())
 }
 
+func TestTruncate(t *testing.T) {
+	c2 := new(C)
+	c2.Merge(c)
+
+	c2.truncate()
+	assert.Equal(t, 0, c2.Len())
+	c.Range(func(k uint64, v uint16) bool {
+		p1, _ := c2.loc(k)
+		assert.NotZero(t, cap(c2.arr[p1]))
+		return true
+	})
+}
+
 func BenchmarkAdd(b *testing.B) {
 	vals := make([][]byte, b.N)
 	for i := 0; i < b.N; i++ {
@@ -120,6 +811,20 @@ func BenchmarkAdd(b *testing.B) {
 	}
 }
 
+func BenchmarkIncr(b *testing.B) {
+	vals := make([][]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		byts := make([]byte, 4)
+		rand.Read(byts)
+		vals[i] = byts
+	}
+	c := new(C)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Incr(vals[i])
+	}
+}
+
 func BenchmarkGet(b *testing.B) {
 	c := new(C)
 	vals := make([][]byte, b.N)
@@ -150,6 +855,98 @@ func BenchmarkGetKey(b *testing.B) {
 	}
 }
 
+func BenchmarkMerge(b *testing.B) {
+	c2 := new(C)
+	for i := 0; i < 1e6; i++ {
+		byts := make([]byte, 4)
+		rand.Read(byts)
+		c2.Add(byts, 1)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c3 := new(C)
+		c3.Merge(c2)
+		c3.Merge(c2)
+	}
+}
+
+// BenchmarkMergeOverlap merges counters that hold the same keys, spread
+// across every partition, at a few different sizes
+func BenchmarkMergeOverlap(b *testing.B) {
+	for _, size := range []int{1e5, 1e6, 1e7, 5e7} {
+		var c2, c3 *C
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			if c2 == nil {
+				// building the partitions directly keeps the setup fast
+				c2, c3 = new(C), new(C)
+				for i := 0; i < size; i++ {
+					p1, id := c2.loc(rand.Uint64())
+					c2.arr[p1] = append(c2.arr[p1], id|1<<idSize)
+					c3.arr[p1] = append(c3.arr[p1], id|1<<idSize)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c2.Merge(c3)
+			}
+		})
+	}
+}
+
+// sortedKeysBench returns a C holding size keys and those keys sorted so they
+// can be added to it again
+func sortedKeysBench(size int) (*C, []uint64, []uint16) {
+	c2 := new(C)
+	ks := make([]uint64, size)
+	counts := make([]uint16, size)
+	for i := range ks {
+		ks[i] = rand.Uint64()
+		counts[i] = 1
+		p1, id := c2.loc(ks[i])
+		c2.arr[p1] = append(c2.arr[p1], id|1<<idSize)
+	}
+	sort.Slice(ks, func(i, j int) bool { return ks[i] < ks[j] })
+	return c2, ks, counts
+}
+
+func BenchmarkAddSortedKeys(b *testing.B) {
+	for _, size := range []int{1e5, 1e6, 1e7} {
+		var c2 *C
+		var ks []uint64
+		var counts []uint16
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			if c2 == nil {
+				c2, ks, counts = sortedKeysBench(size)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c2.AddSortedKeys(ks, counts)
+			}
+		})
+	}
+}
+
+// BenchmarkAddSortedKeysLoop adds the same keys as BenchmarkAddSortedKeys with
+// AddKey for comparison
+func BenchmarkAddSortedKeysLoop(b *testing.B) {
+	for _, size := range []int{1e5, 1e6, 1e7} {
+		var c2 *C
+		var ks []uint64
+		var counts []uint16
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			if c2 == nil {
+				c2, ks, counts = sortedKeysBench(size)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j, k := range ks {
+					c2.AddKey(k, counts[j])
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkKey(b *testing.B) {
 	c := new(C)
 	vals := make([][]byte, b.N)
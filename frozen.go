@@ -0,0 +1,79 @@
+package hashcounter
+
+import (
+	"sort"
+
+	"github.com/cespare/xxhash"
+)
+
+// Frozen is a read-only copy of a C that's returned by Freeze. The entries of
+// every partition are sorted and stored contiguously so lookups can use a
+// binary search. Since it can't be modified, a Frozen is safe for concurrent
+// use.
+type Frozen struct {
+	// the entries of partition p1 are entries[offs[p1]:offs[p1+1]]
+	entries []uint64
+	offs    [1<<part1Size + 1]int
+	hash    func([]byte) uint64
+}
+
+// Freeze returns a Frozen copy of C. C is not modified and can continue to be
+// used without affecting the returned Frozen.
+func (m *C) Freeze() *Frozen {
+	f := &Frozen{
+		entries: make([]uint64, 0, m.Len()),
+		hash:    m.hash,
+	}
+	for p1 := range m.arr {
+		f.offs[p1] = len(f.entries)
+		f.entries = append(f.entries, m.arr[p1]...)
+		sortByID(f.entries[f.offs[p1]:])
+	}
+	f.offs[len(m.arr)] = len(f.entries)
+	return f
+}
+
+// Key returns the uint64 key for the given bytes
+func (f *Frozen) Key(k []byte) uint64 {
+	if f.hash != nil {
+		return f.hash(k)
+	}
+	return xxhash.Sum64(k)
+}
+
+// Get returns the value of the given bytes and a boolean if it was found
+func (f *Frozen) Get(b []byte) (uint16, bool) {
+	return f.GetKey(f.Key(b))
+}
+
+// GetKey takes a key rather than bytes but otherwise behaves like Get
+func (f *Frozen) GetKey(k uint64) (uint16, bool) {
+	p1, id := k>>idSize, k&idBits
+	b := f.entries[f.offs[p1]:f.offs[p1+1]]
+	i := sort.Search(len(b), func(i int) bool {
+		return b[i]&idBits >= id
+	})
+	if i < len(b) && b[i]&idBits == id {
+		return uint16(b[i] >> idSize), true
+	}
+	return 0, false
+}
+
+// Range calls the given function for every value in the Frozen and continues
+// looping until the given bool. Keys are visited in ascending order.
+func (f *Frozen) Range(fn func(key uint64, value uint16) bool) {
+	var key uint64
+	for p1 := 0; p1 < len(f.offs)-1; p1++ {
+		for _, idv := range f.entries[f.offs[p1]:f.offs[p1+1]] {
+			key = uint64(p1)<<(64-part1Size) | idv&idBits
+			if !fn(key, uint16(idv>>idSize)) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns a count of all of the keys
+func (f *Frozen) Len() int {
+	return len(f.entries)
+}
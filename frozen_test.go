@@ -0,0 +1,59 @@
+package hashcounter
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreeze(t *testing.T) {
+	f := c.Freeze()
+	require.Equal(t, c.Len(), f.Len())
+
+	// make sure each key is correct from multiple goroutines at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k, v := range m {
+				v2, ok := f.Get([]byte(k))
+				assert.True(t, ok)
+				assert.Equal(t, v, v2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, ok := f.GetKey(c.Key([]byte(`not in the map`)))
+	assert.False(t, ok)
+
+	var last uint64
+	l := 0
+	f.Range(func(k uint64, v uint16) bool {
+		assert.True(t, l == 0 || k > last)
+		v2, ok := c.GetKey(k)
+		assert.True(t, ok)
+		assert.Equal(t, v2, v)
+		last = k
+		l++
+		return true
+	})
+	assert.Equal(t, c.Len(), l)
+}
+
+func TestFreezeCopies(t *testing.T) {
+	c2 := new(C)
+	c2.Add([]byte(`a`), 1)
+	f := c2.Freeze()
+	c2.Add([]byte(`a`), 1)
+	c2.Add([]byte(`b`), 1)
+
+	v, ok := f.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+	_, ok = f.Get([]byte(`b`))
+	assert.False(t, ok)
+}
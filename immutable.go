@@ -0,0 +1,132 @@
+package hashcounter
+
+import "github.com/cespare/xxhash"
+
+const (
+	immHiSize = part1Size / 2
+	immLoSize = part1Size - immHiSize
+	immLoBits = (1 << immLoSize) - 1
+)
+
+// immNode holds the partitions that share the same upper immHiSize bits
+type immNode [1 << immLoSize][]uint64
+
+// Immutable is a counter that's never modified after it's created. Instead,
+// Add returns a new Immutable that shares every unchanged partition with the
+// original so old versions remain valid and cheap to keep around. Since it
+// can't be modified, an Immutable is safe for concurrent use.
+//
+// Every Add copies a fixed amount of bookkeeping plus the affected partition,
+// so Immutable is slower to add to than C and is best suited for keeping
+// point-in-time versions.
+//
+// The zero value is an empty Immutable that uses the default hash function.
+type Immutable struct {
+	nodes [1 << immHiSize]*immNode
+	len   int
+	hash  func([]byte) uint64
+}
+
+// NewImmutable returns a new empty instance of Immutable
+func NewImmutable() *Immutable {
+	return new(Immutable)
+}
+
+// NewImmutableWithHash returns a new empty instance of Immutable with the
+// provided hash function
+func NewImmutableWithHash(fn func([]byte) uint64) *Immutable {
+	return &Immutable{
+		hash: fn,
+	}
+}
+
+// Key returns the uint64 key for the given bytes
+func (im *Immutable) Key(k []byte) uint64 {
+	if im.hash != nil {
+		return im.hash(k)
+	}
+	return xxhash.Sum64(k)
+}
+
+func (im *Immutable) loc(k uint64) (uint16, uint16, uint64) {
+	p1 := uint16(k >> idSize)
+	return p1 >> immLoSize, p1 & immLoBits, k & idBits
+}
+
+// Add returns a new Immutable with the value added to the given bytes. The
+// called on Immutable is unchanged.
+func (im *Immutable) Add(b []byte, v uint16) *Immutable {
+	return im.AddKey(im.Key(b), v)
+}
+
+// AddKey takes a key rather than bytes but otherwise behaves like Add
+func (im *Immutable) AddKey(k uint64, v uint16) *Immutable {
+	hi, lo, id := im.loc(k)
+
+	n := new(Immutable)
+	*n = *im
+	node := new(immNode)
+	if im.nodes[hi] != nil {
+		*node = *im.nodes[hi]
+	}
+	n.nodes[hi] = node
+
+	// leave room for a new entry so the append below doesn't reallocate
+	arr := make([]uint64, len(node[lo]), len(node[lo])+1)
+	copy(arr, node[lo])
+	node[lo] = arr
+	for i := range arr {
+		if id == arr[i]&idBits {
+			v64 := arr[i]>>idSize + uint64(v)
+			arr[i] = v64<<idSize | id
+			return n
+		}
+	}
+	node[lo] = append(arr, id+uint64(v)<<idSize)
+	n.len++
+	return n
+}
+
+// Get returns the value of the given bytes and a boolean if it was found
+func (im *Immutable) Get(b []byte) (uint16, bool) {
+	return im.GetKey(im.Key(b))
+}
+
+// GetKey takes a key rather than bytes but otherwise behaves like Get
+func (im *Immutable) GetKey(k uint64) (uint16, bool) {
+	hi, lo, id := im.loc(k)
+	if im.nodes[hi] == nil {
+		return 0, false
+	}
+	for _, idv := range im.nodes[hi][lo] {
+		if id == idv&idBits {
+			return uint16(idv >> idSize), true
+		}
+	}
+	return 0, false
+}
+
+// Range calls the given function for every value in the Immutable and
+// continues looping until the given bool
+func (im *Immutable) Range(f func(key uint64, value uint16) bool) {
+	var key uint64
+	for hi, node := range im.nodes {
+		if node == nil {
+			continue
+		}
+		for lo := range node {
+			p1 := uint64(hi)<<immLoSize | uint64(lo)
+			for _, idv := range node[lo] {
+				key = p1<<(64-part1Size) | idv&idBits
+				if !f(key, uint16(idv>>idSize)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Len returns a count of all of the keys
+func (im *Immutable) Len() int {
+	return im.len
+}
@@ -0,0 +1,71 @@
+package hashcounter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImmutable(t *testing.T) {
+	im := new(Immutable)
+	versions := []*Immutable{im}
+	for i := 0; i < 100; i++ {
+		im = im.Add([]byte{byte(i % 10)}, 1)
+		versions = append(versions, im)
+	}
+	assert.Equal(t, 10, im.Len())
+	for i := 0; i < 10; i++ {
+		v, ok := im.Get([]byte{byte(i)})
+		require.True(t, ok)
+		assert.Equal(t, uint16(10), v)
+	}
+
+	// every older version should be unchanged
+	for i, im := range versions {
+		if i < 10 {
+			assert.Equal(t, i, im.Len())
+		} else {
+			assert.Equal(t, 10, im.Len())
+		}
+		for j := 0; j < 10; j++ {
+			// the number of times j was added before version i
+			exp := uint16(i / 10)
+			if j < i%10 {
+				exp++
+			}
+			v, ok := im.Get([]byte{byte(j)})
+			assert.Equal(t, exp > 0, ok)
+			assert.Equal(t, exp, v)
+		}
+	}
+
+	l := 0
+	im.Range(func(k uint64, v uint16) bool {
+		assert.Equal(t, uint16(10), v)
+		l++
+		return true
+	})
+	assert.Equal(t, 10, l)
+}
+
+func TestImmutableSamePartition(t *testing.T) {
+	im := NewImmutableWithHash(func(b []byte) uint64 { return uint64(len(b)) })
+	im2 := im.Add([]byte(`a`), 1)
+	im3 := im2.Add([]byte(`ab`), 2)
+	im4 := im3.Add([]byte(`a`), 3)
+
+	v, ok := im2.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+	_, ok = im2.Get([]byte(`ab`))
+	assert.False(t, ok)
+
+	v, ok = im4.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(4), v)
+	v, ok = im3.Get([]byte(`a`))
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+	assert.Equal(t, 2, im4.Len())
+}
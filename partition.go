@@ -0,0 +1,36 @@
+package hashcounter
+
+import "fmt"
+
+// PartitionOf returns the partition that C stores the given key in
+func PartitionOf(key uint64) uint16 {
+	return uint16(key >> (64 - part1Size))
+}
+
+// OwnedBy returns true if the partition p1 belongs to node self when the
+// partitions are split between the given number of nodes. Each node owns a
+// contiguous range of partitions and every node makes the same decision, so a
+// cluster can use it to split the keyspace without coordinating. Since there
+// are only 1<<16 partitions, nodes can be at most that many. It returns false
+// if nodes is less than 1 or more than 1<<16 or self isn't in [0, nodes).
+func OwnedBy(p1 uint16, nodes int, self int) bool {
+	if nodes < 1 || nodes > 1<<part1Size || self < 0 || self >= nodes {
+		return false
+	}
+	return int(uint64(p1)*uint64(nodes)>>part1Size) == self
+}
+
+// Restrict limits C to the partitions owned by node self out of the given
+// number of nodes, as determined by OwnedBy. Once restricted, every method
+// that adds, sets or gets a key or bytes panics if the key falls in a
+// partition that isn't owned, since that means it was routed to the wrong
+// node. Key and KeyString only hash and never panic, and Merge, MergeMax,
+// MergeMin, UnmarshalBinary and UnmarshalBinaryPartial are not checked.
+// Calling Restrict with nodes of 0 removes the restriction. It panics if nodes
+// is more than 1<<16, the number of partitions.
+func (m *C) Restrict(nodes, self int) {
+	if nodes != 0 && (nodes < 0 || nodes > 1<<part1Size || self < 0 || self >= nodes) {
+		panic(fmt.Sprintf("hashcounter: invalid node %d of %d", self, nodes))
+	}
+	m.nodes, m.self = nodes, self
+}
@@ -0,0 +1,72 @@
+package hashcounter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionOf(t *testing.T) {
+	c.Range(func(k uint64, v uint16) bool {
+		p1, _ := c.loc(k)
+		assert.Equal(t, p1, PartitionOf(k))
+		return true
+	})
+}
+
+func TestOwnedBy(t *testing.T) {
+	for _, nodes := range []int{1, 2, 3, 7, 100} {
+		counts := make([]int, nodes)
+		last := 0
+		for p1 := 0; p1 < 1<<part1Size; p1++ {
+			owners := 0
+			for self := 0; self < nodes; self++ {
+				if OwnedBy(uint16(p1), nodes, self) {
+					owners++
+					// ranges should be contiguous and ascending
+					assert.True(t, self >= last)
+					last = self
+					counts[self]++
+				}
+			}
+			assert.Equal(t, 1, owners)
+		}
+		// nodes should get roughly the same number of partitions
+		for _, cnt := range counts {
+			assert.InDelta(t, (1<<part1Size)/nodes, cnt, 1)
+		}
+	}
+
+	assert.False(t, OwnedBy(0, 0, 0))
+	assert.False(t, OwnedBy(0, 2, 2))
+	assert.False(t, OwnedBy(0, 2, -1))
+
+	// with as many nodes as partitions each node owns exactly one
+	for p1 := 0; p1 < 1<<part1Size; p1++ {
+		assert.True(t, OwnedBy(uint16(p1), 1<<part1Size, p1))
+		assert.False(t, OwnedBy(uint16(p1), 1<<part1Size, (p1+1)%(1<<part1Size)))
+	}
+
+	// more nodes than partitions would leave some owning nothing
+	assert.False(t, OwnedBy(0, 1<<part1Size+1, 0))
+	assert.False(t, OwnedBy(0, 1<<49, 0))
+}
+
+func TestRestrict(t *testing.T) {
+	c2 := new(C)
+	c2.Restrict(2, 0)
+	low, high := uint64(1), uint64(1<<63|1)
+	c2.AddKey(low, 1)
+	v, ok := c2.GetKey(low)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), v)
+	assert.Panics(t, func() { c2.AddKey(high, 1) })
+	assert.Panics(t, func() { c2.GetKey(high) })
+
+	c2.Restrict(0, 0)
+	c2.AddKey(high, 1)
+	assert.Equal(t, 2, c2.Len())
+
+	assert.Panics(t, func() { c2.Restrict(2, 2) })
+	assert.Panics(t, func() { c2.Restrict(1<<part1Size+1, 0) })
+}
@@ -0,0 +1,56 @@
+package hashcounter
+
+import "sync"
+
+// Pool holds C's that can be reused once they're no longer needed. C's put
+// back into the Pool are emptied but keep the capacity of their partitions so
+// adding to them again allocates less. A Pool must not be copied after first
+// use.
+//
+// The zero value is ready to use and hands out C's that use the default hash
+// function.
+type Pool struct {
+	p          sync.Pool
+	hash       func([]byte) uint64
+	hashString func(string) uint64
+}
+
+// NewPool returns a new instance of Pool
+func NewPool() *Pool {
+	return new(Pool)
+}
+
+// NewPoolWithHash returns a new instance of Pool that hands out C's with the
+// provided hash function
+func NewPoolWithHash(fn func([]byte) uint64) *Pool {
+	return &Pool{
+		hash: fn,
+	}
+}
+
+// NewPoolWithHashes returns a new instance of Pool that hands out C's with the
+// provided hash functions. See NewWithHashes.
+func NewPoolWithHashes(fn func([]byte) uint64, fnString func(string) uint64) *Pool {
+	return &Pool{
+		hash:       fn,
+		hashString: fnString,
+	}
+}
+
+// Get returns an empty C from the Pool, creating one if necessary
+func (p *Pool) Get() *C {
+	if m, ok := p.p.Get().(*C); ok {
+		return m
+	}
+	return NewWithHashes(p.hash, p.hashString)
+}
+
+// Put empties the given C and adds it to the Pool. The C must not be used after
+// calling Put.
+func (p *Pool) Put(m *C) {
+	m.truncate()
+	m.hash, m.hashString = p.hash, p.hashString
+	m.nodes, m.self = 0, 0
+	m.threshold, m.onThreshold = 0, nil
+	p.p.Put(m)
+}
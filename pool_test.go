@@ -0,0 +1,37 @@
+package hashcounter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPool(t *testing.T) {
+	p := NewPoolWithHash(func(b []byte) uint64 { return uint64(len(b)) })
+	for i := 0; i < 3; i++ {
+		c2 := p.Get()
+		assert.Equal(t, 0, c2.Len())
+		assert.Equal(t, uint64(3), c2.Key([]byte(`abc`)))
+
+		c2.Add([]byte(`abc`), 1)
+		c2.Add([]byte(`abcd`), 1)
+		assert.Equal(t, 2, c2.Len())
+		p.Put(c2)
+	}
+
+	// a C that wasn't from the Pool gets the Pool's hash function
+	p.Put(new(C))
+	assert.Equal(t, uint64(3), p.Get().Key([]byte(`abc`)))
+}
+
+func TestPoolWithHashes(t *testing.T) {
+	// the string hash is deliberately different so we can tell it was used
+	p := NewPoolWithHashes(
+		func(b []byte) uint64 { return uint64(len(b)) },
+		func(s string) uint64 { return uint64(len(s)) + 1 },
+	)
+	c2 := p.Get()
+	assert.Equal(t, uint64(4), c2.KeyString(`abc`))
+	p.Put(c2)
+	assert.Equal(t, uint64(4), p.Get().KeyString(`abc`))
+}
@@ -0,0 +1,143 @@
+package hashcounter
+
+import "time"
+
+// Rate counts values like C but also keeps separate counts for each of the
+// most recent intervals so the rate of a key over a recent window can be
+// reported. Each interval is stored in its own C so memory usage grows with
+// the number of intervals. The exposed functions are not thread-safe.
+type Rate struct {
+	total    *C
+	slots    []*C
+	interval time.Duration
+
+	// cur is the index of the slot for the interval that began at start
+	cur     int
+	start   time.Time
+	created time.Time
+	now     func() time.Time
+}
+
+// NewRate returns a new instance of Rate that keeps counts for the given
+// number of intervals of the given length. Rate can report over windows up to
+// interval*slots long.
+//
+// Every slot, and the lifetime total, is a C with a fixed array of 1<<16
+// partitions that costs 1.5MiB on 64-bit platforms before any keys are added.
+// So NewRate(time.Second, 60) uses about 92MiB up front.
+func NewRate(interval time.Duration, slots int) *Rate {
+	return NewRateWithHash(nil, interval, slots)
+}
+
+// NewRateWithHash returns a new instance of Rate with the provided hash
+// function, otherwise it behaves like NewRate
+func NewRateWithHash(fn func([]byte) uint64, interval time.Duration, slots int) *Rate {
+	if interval <= 0 || slots < 1 {
+		panic("hashcounter: NewRate requires a positive interval and slots")
+	}
+	r := &Rate{
+		total:    NewWithHash(fn),
+		slots:    make([]*C, slots),
+		interval: interval,
+		now:      time.Now,
+	}
+	for i := range r.slots {
+		r.slots[i] = NewWithHash(fn)
+	}
+	return r
+}
+
+// advance moves the current slot forward to the interval containing now,
+// emptying any slots that are reused. It must not be called before the first
+// Add.
+func (r *Rate) advance(now time.Time) {
+	n := int(now.Sub(r.start) / r.interval)
+	if n < 1 {
+		return
+	}
+	r.start = r.start.Add(time.Duration(n) * r.interval)
+	if n > len(r.slots) {
+		n = len(r.slots)
+	}
+	for i := 0; i < n; i++ {
+		r.cur = (r.cur + 1) % len(r.slots)
+		r.slots[r.cur].truncate()
+	}
+}
+
+// Key returns the uint64 key for the given bytes
+func (r *Rate) Key(b []byte) uint64 {
+	return r.total.Key(b)
+}
+
+// Add adds the value to the given bytes
+func (r *Rate) Add(b []byte, v uint16) {
+	r.AddKey(r.Key(b), v)
+}
+
+// AddKey takes a key rather than bytes but otherwise behaves like Add
+func (r *Rate) AddKey(k uint64, v uint16) {
+	now := r.now()
+	if r.created.IsZero() {
+		r.start, r.created = now, now
+	}
+	r.advance(now)
+	r.total.AddKey(k, v)
+	r.slots[r.cur].AddKey(k, v)
+}
+
+// Get returns the lifetime value of the given bytes and a boolean if it was
+// found
+func (r *Rate) Get(b []byte) (uint16, bool) {
+	return r.total.Get(b)
+}
+
+// GetKey takes a key rather than bytes but otherwise behaves like Get
+func (r *Rate) GetKey(k uint64) (uint16, bool) {
+	return r.total.GetKey(k)
+}
+
+// Rate returns the average number of events per second for the given bytes
+// over the most recent window. The window is rounded up to a whole number of
+// intervals and is capped to the intervals that are kept and to the time since
+// the first Add.
+func (r *Rate) Rate(b []byte, window time.Duration) float64 {
+	return r.RateKey(r.Key(b), window)
+}
+
+// RateKey takes a key rather than bytes but otherwise behaves like Rate
+func (r *Rate) RateKey(k uint64, window time.Duration) float64 {
+	if r.created.IsZero() {
+		return 0
+	}
+	now := r.now()
+	r.advance(now)
+	// clamp before rounding up so a window near math.MaxInt64 can't overflow
+	if window/r.interval >= time.Duration(len(r.slots)) {
+		window = r.interval * time.Duration(len(r.slots))
+	}
+	n := int(window / r.interval)
+	if window%r.interval > 0 {
+		n++
+	}
+	if n < 1 {
+		return 0
+	}
+
+	// the current interval is only partially over
+	dur := time.Duration(n-1)*r.interval + now.Sub(r.start)
+	if lived := now.Sub(r.created); lived < dur {
+		dur = lived
+	}
+	if dur <= 0 {
+		return 0
+	}
+
+	var sum uint64
+	for i := 0; i < n; i++ {
+		slot := (r.cur - i + len(r.slots)) % len(r.slots)
+		v, _ := r.slots[slot].GetKey(k)
+		sum += uint64(v)
+	}
+	return float64(sum) / dur.Seconds()
+}
@@ -0,0 +1,75 @@
+package hashcounter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewRate(time.Second, 10)
+	r.now = func() time.Time { return now }
+
+	b := []byte(`a`)
+	assert.Equal(t, float64(0), r.Rate(b, 10*time.Second))
+
+	// 10 per second for 10 seconds
+	for i := 0; i < 10; i++ {
+		r.Add(b, 10)
+		now = now.Add(time.Second)
+	}
+	assert.InDelta(t, 10, r.Rate(b, 10*time.Second), 0.001)
+	assert.InDelta(t, 10, r.Rate(b, 3*time.Second), 0.001)
+
+	// nothing for 5 seconds, the 10 second window now includes 4 of the
+	// previous seconds plus the current one which just started
+	now = now.Add(5 * time.Second)
+	assert.InDelta(t, 0, r.Rate(b, 5*time.Second), 0.001)
+	assert.InDelta(t, 40.0/9, r.Rate(b, 10*time.Second), 0.001)
+
+	// windows longer than what's kept are capped
+	assert.InDelta(t, 40.0/9, r.Rate(b, time.Minute), 0.001)
+	assert.InDelta(t, 40.0/9, r.Rate(b, math.MaxInt64), 0.001)
+
+	// the lifetime total is unaffected
+	v, ok := r.Get(b)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(100), v)
+
+	// after all of the slots expire the rate is 0
+	now = now.Add(time.Hour)
+	assert.Equal(t, float64(0), r.Rate(b, 10*time.Second))
+	v, ok = r.Get(b)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(100), v)
+
+	_, ok = r.Get([]byte(`b`))
+	assert.False(t, ok)
+}
+
+func TestRateStartup(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewRate(time.Second, 10)
+	r.now = func() time.Time { return now }
+
+	// the window is capped to the time since the first Add
+	r.Add([]byte(`a`), 4)
+	now = now.Add(2 * time.Second)
+	assert.InDelta(t, 2, r.Rate([]byte(`a`), 10*time.Second), 0.001)
+}
+
+func TestRateBeforeAdd(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewRate(time.Second, 10)
+	r.now = func() time.Time { return now }
+
+	// calling Rate before any Add shouldn't count as the start
+	assert.Equal(t, float64(0), r.Rate([]byte(`a`), 10*time.Second))
+	now = now.Add(8 * time.Second)
+	r.Add([]byte(`a`), 8)
+	now = now.Add(time.Second)
+	assert.InDelta(t, 8, r.Rate([]byte(`a`), 10*time.Second), 0.001)
+}
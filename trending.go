@@ -0,0 +1,100 @@
+package hashcounter
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Trend describes how the value of a key changed between two C's
+type Trend struct {
+	Key   uint64
+	Count uint16
+	Prev  uint16
+}
+
+// Increase returns how much the value of the key went up
+func (t Trend) Increase() int {
+	return int(t.Count) - int(t.Prev)
+}
+
+// less orders Trends by increase and then by descending key so the order is
+// deterministic
+func (t Trend) less(o Trend) bool {
+	if t.Increase() != o.Increase() {
+		return t.Increase() < o.Increase()
+	}
+	return t.Key > o.Key
+}
+
+// trendHeap is a min-heap of Trends used to keep the top k
+type trendHeap []Trend
+
+func (h trendHeap) Len() int            { return len(h) }
+func (h trendHeap) Less(i, j int) bool  { return h[i].less(h[j]) }
+func (h trendHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *trendHeap) Push(x interface{}) { *h = append(*h, x.(Trend)) }
+func (h *trendHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+// Trending returns up to k keys whose values increased the most since prev,
+// which is typically an earlier snapshot of the same C. A nil prev is treated
+// as empty so every key counts as new. Keys that didn't increase are never
+// returned. The returned Trends are ordered from the largest increase to the
+// smallest. This assumes the hash functions are the same.
+//
+// Keys are ranked by their absolute increase rather than by the ratio of
+// Count to Prev. Every key that's new since prev has an infinite ratio, so
+// ranking by ratio would return only new keys and would rank a key going from
+// 1 to 3 above one going from 1000 to 2000.
+func (m *C) Trending(prev *C, k int) []Trend {
+	if k < 1 {
+		return nil
+	}
+	// k can be large to mean every key, so don't reserve more than m has
+	h := make(trendHeap, 0, min(k, m.Len()))
+	var cur, old []uint64
+	for p1 := range m.arr {
+		if len(m.arr[p1]) < 1 {
+			continue
+		}
+		// sort copies so neither C is modified
+		cur = append(cur[:0], m.arr[p1]...)
+		sortByID(cur)
+		old = old[:0]
+		if prev != nil {
+			old = append(old, prev.arr[p1]...)
+			sortByID(old)
+		}
+
+		for _, idv := range cur {
+			id := idv & idBits
+			for len(old) > 0 && old[0]&idBits < id {
+				old = old[1:]
+			}
+			t := Trend{
+				Key:   uint64(p1)<<(64-part1Size) | id,
+				Count: uint16(idv >> idSize),
+			}
+			if len(old) > 0 && old[0]&idBits == id {
+				t.Prev = uint16(old[0] >> idSize)
+			}
+			if t.Increase() < 1 {
+				continue
+			}
+			if len(h) < k {
+				heap.Push(&h, t)
+			} else if h[0].less(t) {
+				h[0] = t
+				heap.Fix(&h, 0)
+			}
+		}
+	}
+
+	ts := []Trend(h)
+	sort.Slice(ts, func(i, j int) bool { return ts[j].less(ts[i]) })
+	return ts
+}
@@ -0,0 +1,77 @@
+package hashcounter
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrending(t *testing.T) {
+	prev := new(C)
+	prev.AddKey(1, 10)
+	prev.AddKey(2, 10)
+	prev.AddKey(3, 10)
+	prev.AddKey(1<<63, 5)
+
+	cur := new(C)
+	cur.Merge(prev)
+	cur.AddKey(1, 1)     // +1
+	cur.AddKey(2, 20)    // +20
+	cur.AddKey(4, 7)     // new, +7
+	cur.AddKey(1<<63, 7) // +7
+
+	assert.Equal(t, []Trend{
+		{Key: 2, Count: 30, Prev: 10},
+		{Key: 4, Count: 7, Prev: 0},
+		{Key: 1 << 63, Count: 12, Prev: 5},
+	}, cur.Trending(prev, 3))
+
+	// key 3 didn't increase so it's never returned
+	ts := cur.Trending(prev, 10)
+	assert.Len(t, ts, 4)
+	assert.Equal(t, 1, ts[3].Increase())
+
+	// a nil prev means everything is new
+	assert.Equal(t, []Trend{
+		{Key: 2, Count: 30},
+		{Key: 1 << 63, Count: 12},
+	}, cur.Trending(nil, 2))
+
+	assert.Empty(t, cur.Trending(cur, 10))
+
+	// a large k returns every key that increased
+	assert.Len(t, cur.Trending(prev, math.MaxInt), 4)
+	assert.Len(t, cur.Trending(nil, 1e9), 5)
+	assert.Empty(t, cur.Trending(prev, 0))
+
+	// prev isn't modified
+	v, ok := prev.GetKey(2)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(10), v)
+}
+
+func TestTrendingLarge(t *testing.T) {
+	c2 := new(C)
+	c2.Merge(c)
+	mkeys := map[uint64]uint16{}
+	for k := range m {
+		mkeys[c.Key([]byte(k))] = 0
+	}
+	i := 0
+	for k := range mkeys {
+		if i >= 100 {
+			break
+		}
+		c2.AddKey(k, uint16(i+1))
+		mkeys[k] = uint16(i + 1)
+		i++
+	}
+
+	ts := c2.Trending(c, 10)
+	assert.Len(t, ts, 10)
+	for j, tr := range ts {
+		assert.Equal(t, 100-j, tr.Increase())
+		assert.Equal(t, mkeys[tr.Key], uint16(tr.Increase()))
+	}
+}